@@ -0,0 +1,140 @@
+package consumer
+
+import (
+	"sync"
+	"time"
+
+	"github.com/Shopify/sarama"
+	"github.com/supershabam/sarama-cg"
+)
+
+// BatchConfig configures a Batch.
+type BatchConfig struct {
+	Consumer cg.Consumer
+	// MaxMessages flushes the current batch once it holds this many
+	// messages. Zero disables the count trigger.
+	MaxMessages int
+	// MaxBytes flushes the current batch once the cumulative size of its
+	// messages (len(Key)+len(Value)) reaches this many bytes. Zero
+	// disables the byte trigger.
+	MaxBytes int
+	// MaxWait flushes whatever is in the current batch, even if the
+	// count and byte triggers haven't fired, this long after the batch's
+	// first message arrived. Zero disables the wait trigger.
+	MaxWait time.Duration
+}
+
+// Batch wraps a cg.Consumer and groups its messages into batches using
+// count, cumulative-byte-size, and max-wait triggers. Consumers that
+// write to databases or object stores, where per-message writes are
+// prohibitive, should read off ConsumeBatch instead of the wrapped
+// consumer's Consume channel.
+type Batch struct {
+	cfg *BatchConfig
+	out chan []*sarama.ConsumerMessage
+
+	mu       sync.Mutex
+	acked    int64
+	hasAcked bool
+}
+
+// NewBatch creates a Batch that is ready to begin reading.
+func NewBatch(cfg *BatchConfig) *Batch {
+	b := &Batch{
+		cfg: cfg,
+		out: make(chan []*sarama.ConsumerMessage),
+	}
+	go b.run()
+	return b
+}
+
+// ConsumeBatch returns a channel of message batches assembled from the
+// wrapped consumer according to the configured triggers. This channel
+// closes when the wrapped consumer's Consume channel closes.
+func (b *Batch) ConsumeBatch() <-chan []*sarama.ConsumerMessage {
+	return b.out
+}
+
+// MarkBatchDone records the highest offset in batch as fully acked, so
+// that a subsequent CommitOffset call commits it. Callers should call
+// this only once they've durably processed every message in batch.
+func (b *Batch) MarkBatchDone(batch []*sarama.ConsumerMessage) {
+	if len(batch) == 0 {
+		return
+	}
+	highest := batch[0].Offset
+	for _, msg := range batch[1:] {
+		if msg.Offset > highest {
+			highest = msg.Offset
+		}
+	}
+	b.mu.Lock()
+	if !b.hasAcked || highest > b.acked {
+		b.acked = highest
+		b.hasAcked = true
+	}
+	b.mu.Unlock()
+}
+
+// CommitOffset writes the highest offset among the batches marked done
+// with MarkBatchDone so far. It is a no-op, returning nil, if no batch
+// has been marked done yet.
+func (b *Batch) CommitOffset() error {
+	b.mu.Lock()
+	offset, ok := b.acked, b.hasAcked
+	b.mu.Unlock()
+	if !ok {
+		return nil
+	}
+	return b.cfg.Consumer.CommitOffset(offset)
+}
+
+// Err should be called after the ConsumeBatch channel closes to determine
+// if there was an error during processing.
+func (b *Batch) Err() error {
+	return b.cfg.Consumer.Err()
+}
+
+func (b *Batch) run() {
+	defer close(b.out)
+	var (
+		batch  []*sarama.ConsumerMessage
+		size   int
+		timer  *time.Timer
+		timerC <-chan time.Time
+	)
+	flush := func() {
+		if len(batch) == 0 {
+			return
+		}
+		b.out <- batch
+		batch = nil
+		size = 0
+		if timer != nil {
+			timer.Stop()
+			timer = nil
+			timerC = nil
+		}
+	}
+	for {
+		select {
+		case msg, ok := <-b.cfg.Consumer.Consume():
+			if !ok {
+				flush()
+				return
+			}
+			if len(batch) == 0 && b.cfg.MaxWait > 0 {
+				timer = time.NewTimer(b.cfg.MaxWait)
+				timerC = timer.C
+			}
+			batch = append(batch, msg)
+			size += len(msg.Key) + len(msg.Value)
+			if (b.cfg.MaxMessages > 0 && len(batch) >= b.cfg.MaxMessages) ||
+				(b.cfg.MaxBytes > 0 && size >= b.cfg.MaxBytes) {
+				flush()
+			}
+		case <-timerC:
+			flush()
+		}
+	}
+}
@@ -0,0 +1,132 @@
+package consumer
+
+import (
+	"github.com/Shopify/sarama"
+)
+
+// Direction tells a Seeker which way to continue narrowing the search
+// after probing an offset.
+type Direction int
+
+const (
+	// SeekLower indicates the probed offset is past the target; continue
+	// narrowing toward lower offsets.
+	SeekLower Direction = iota
+	// SeekHigher indicates the probed offset is short of the target;
+	// continue narrowing toward higher offsets.
+	SeekHigher
+	// SeekFound indicates the probed offset satisfies the predicate and
+	// the search should stop here.
+	SeekFound
+)
+
+// Predicate inspects the message at a probed offset and reports which
+// direction the search should continue in. Returning ErrSkipOffset causes
+// the Seeker to probe an adjacent offset instead of failing the search,
+// which is useful when a message carries no value relevant to the search
+// (a tombstone, a control record, a different schema version).
+type Predicate func(*sarama.ConsumerMessage) (Direction, error)
+
+// Seeker performs a binary search over a topic-partition's offsets to
+// locate the offset satisfying a caller-supplied Predicate. TimeWindow's
+// binarySearch is one such caller; a Predicate can just as well locate
+// offsets by sequence number, transaction id, schema version, or any
+// other field parsed out of a message.
+type Seeker struct {
+	client sarama.Client
+	// InitialOffsetHint, when set, is consulted before falling back to
+	// bisection from the partition bounds. This lets callers reuse a
+	// native index (such as Kafka's time index) to shortcut the search
+	// when it applies; a returned error is ignored and the Seeker falls
+	// back to the midpoint of the partition bounds.
+	InitialOffsetHint func(topic string, partition int32) (int64, error)
+}
+
+// NewSeeker creates a Seeker that probes offsets on the given client.
+func NewSeeker(client sarama.Client) *Seeker {
+	return &Seeker{client: client}
+}
+
+// Seek narrows between the oldest and newest offsets of topic-partition,
+// fetching the message at each probed offset with fetch and asking pred
+// which way to continue. It returns once pred reports SeekFound, or once
+// the search has converged to a single offset.
+func (s *Seeker) Seek(topic string, partition int32, fetch func(offset int64) (*sarama.ConsumerMessage, error), pred Predicate) (int64, error) {
+	lower, upper, err := s.bounds(topic, partition)
+	if err != nil {
+		return 0, err
+	}
+	offset := (lower + upper) / 2
+	if s.InitialOffsetHint != nil {
+		if hint, err := s.InitialOffsetHint(topic, partition); err == nil {
+			offset = hint
+		}
+	}
+	for offset != lower && offset != upper {
+		dir, err := s.probe(fetch, offset, upper, pred)
+		if err != nil {
+			return 0, err
+		}
+		switch dir {
+		case SeekFound:
+			return offset, nil
+		case SeekLower:
+			upper = offset
+			offset = (lower + offset) / 2
+		case SeekHigher:
+			lower = offset
+			offset = (offset + upper) / 2
+		}
+	}
+	return offset, nil
+}
+
+// probe fetches and evaluates the message at offset, walking forward
+// toward upper whenever pred reports ErrSkipOffset.
+func (s *Seeker) probe(fetch func(int64) (*sarama.ConsumerMessage, error), offset, upper int64, pred Predicate) (Direction, error) {
+	var dir Direction
+	_, _, err := fetchSkipping(fetch, offset, upper, func(msg *sarama.ConsumerMessage) error {
+		d, err := pred(msg)
+		if err != nil {
+			return err
+		}
+		dir = d
+		return nil
+	})
+	return dir, err
+}
+
+// fetchSkipping fetches the message at offset, walking forward toward
+// upper whenever accept reports ErrSkipOffset, and returns the message
+// accept settled on together with the offset it was found at. This is
+// the shared skip-ahead contract behind ErrSkipOffset: a TimeExtractor
+// or Predicate may return it to mean "this offset carries nothing
+// relevant to the search, try the next one" rather than failing the
+// search outright. Both Seeker.probe and TimeWindow's interpolation
+// search route through this so the contract is honored identically
+// regardless of which narrowing strategy is choosing offsets.
+func fetchSkipping(fetch func(int64) (*sarama.ConsumerMessage, error), offset, upper int64, accept func(*sarama.ConsumerMessage) error) (*sarama.ConsumerMessage, int64, error) {
+	for o := offset; o < upper; o++ {
+		msg, err := fetch(o)
+		if err != nil {
+			return nil, 0, err
+		}
+		if err := accept(msg); err != nil {
+			if err == ErrSkipOffset {
+				continue
+			}
+			return nil, 0, err
+		}
+		return msg, o, nil
+	}
+	return nil, 0, ErrSkipOffset
+}
+
+func (s *Seeker) bounds(topic string, partition int32) (lower, upper int64, err error) {
+	lower, err = s.client.GetOffset(topic, partition, sarama.OffsetOldest)
+	if err != nil {
+		return
+	}
+	upper, err = s.client.GetOffset(topic, partition, sarama.OffsetNewest)
+	return
+}
@@ -2,6 +2,7 @@ package consumer
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"time"
 
@@ -9,6 +10,12 @@ import (
 	"github.com/supershabam/sarama-cg"
 )
 
+// ErrSkipOffset may be returned by a TimeExtractor to indicate that the
+// message at this offset carries no usable event time (for example a
+// tombstone or control record). binarySearch treats this as a signal to
+// probe an adjacent offset rather than fail the seek.
+var ErrSkipOffset = errors.New("consumer: skip offset, no usable event time")
+
 // StartPosition is where the TimeWindow should start at to seek back
 // the Window duration.
 type StartPosition int
@@ -31,6 +38,22 @@ type TimeWindowConfig struct {
 	Partition     int32
 	Topic         string
 	Window        time.Duration
+	// TimeExtractor computes the event time to use for seeking from a
+	// Kafka message. When nil, NewTimeWindow defaults to a function that
+	// returns msg.Timestamp, the broker-assigned timestamp. Pipelines
+	// that backfill data, replay from another system, or encode
+	// event-time in the payload/headers can supply their own extractor
+	// to seek against a different notion of time. Returning
+	// ErrSkipOffset tells binarySearch to probe an adjacent offset
+	// rather than fail the seek.
+	TimeExtractor func(*sarama.ConsumerMessage) (time.Time, error)
+	// InterpolationSearch, when true, narrows toward the target offset by
+	// interpolating between the timestamps at the current bounds instead
+	// of bisecting at the midpoint. On topics with a roughly uniform
+	// message rate this reduces the number of probes from O(log N) to
+	// O(log log N); it falls back to bisection automatically whenever
+	// interpolation can't usefully narrow the interval.
+	InterpolationSearch bool
 }
 
 // Ensures that TimeWindow fulfils Consumer interface.
@@ -40,20 +63,30 @@ var _ cg.Consumer = &TimeWindow{}
 // for the given partition-topic, discovers what time that message happened, and
 // then rewinds to past offsets until the provided Window of time is acheived.
 type TimeWindow struct {
-	client sarama.Client
-	coord  *cg.Coordinator
-	sc     *Seek
-	start  StartPosition
-	window time.Duration
+	client      sarama.Client
+	coord       *cg.Coordinator
+	sc          *Seek
+	start       StartPosition
+	window      time.Duration
+	extractor   func(*sarama.ConsumerMessage) (time.Time, error)
+	interpolate bool
 }
 
 // NewTimeWindow creates a new consumer that is ready to begin reading.
 func NewTimeWindow(cfg *TimeWindowConfig) (*TimeWindow, error) {
+	extractor := cfg.TimeExtractor
+	if extractor == nil {
+		extractor = func(msg *sarama.ConsumerMessage) (time.Time, error) {
+			return msg.Timestamp, nil
+		}
+	}
 	twc := &TimeWindow{
-		client: cfg.Client,
-		coord:  cfg.Coordinator,
-		start:  cfg.Start,
-		window: cfg.Window,
+		client:      cfg.Client,
+		coord:       cfg.Coordinator,
+		start:       cfg.Start,
+		window:      cfg.Window,
+		extractor:   extractor,
+		interpolate: cfg.InterpolationSearch,
 	}
 	sc, err := NewSeek(&SeekConfig{
 		CacheDuration: cfg.CacheDuration,
@@ -114,64 +147,145 @@ func (twc *TimeWindow) seek(topic string, partition int32) (int64, error) {
 		return 0, err
 	}
 	target := t.Add(-twc.window)
+	if twc.interpolate {
+		return twc.interpolationSearch(topic, partition, target)
+	}
 	return twc.binarySearch(topic, partition, target)
 }
 
+// binarySearch locates the offset nearest to target by delegating to a
+// Seeker: the predicate narrows lower whenever a probed message's
+// extracted time is after target, and higher otherwise. GetOffset can at
+// best return the segment the desired time starts in, not an accurate
+// offset, so it is only used as an InitialOffsetHint.
 func (twc *TimeWindow) binarySearch(topic string, partition int32, target time.Time) (int64, error) {
-	lower, upper, err := twc.bounds(topic, partition)
+	seeker := NewSeeker(twc.client)
+	seeker.InitialOffsetHint = func(topic string, partition int32) (int64, error) {
+		return twc.client.GetOffset(topic, partition, target.UnixNano()/int64(time.Millisecond))
+	}
+	return seeker.Seek(topic, partition,
+		func(offset int64) (*sarama.ConsumerMessage, error) {
+			return twc.messageAt(topic, partition, offset)
+		},
+		func(msg *sarama.ConsumerMessage) (Direction, error) {
+			t, err := twc.extractor(msg)
+			if err != nil {
+				return 0, err
+			}
+			if t.After(target) {
+				return SeekLower, nil
+			}
+			return SeekHigher, nil
+		},
+	)
+}
+
+// interpolationSearch narrows toward target by interpolating between the
+// timestamps observed at the current lower and upper bounds instead of
+// bisecting at the midpoint, which on topics with a roughly uniform
+// message rate converges in O(log log N) probes instead of O(log N). It
+// caches the timestamps at the bounds between iterations so each
+// iteration costs a single probe rather than two, and it never probes
+// the partition's exact lower/upper bounds up front -- like
+// binarySearch, it only learns a bound's timestamp once a probe lands
+// there. Probing goes through fetchSkipping, the same skip-ahead helper
+// Seeker.probe uses, so a TimeExtractor returning ErrSkipOffset walks
+// forward to an adjacent offset here exactly as it does in binarySearch,
+// instead of aborting the seek.
+func (twc *TimeWindow) interpolationSearch(topic string, partition int32, target time.Time) (int64, error) {
+	lower, upper, err := NewSeeker(twc.client).bounds(topic, partition)
 	if err != nil {
 		return 0, err
 	}
-	// GetOffset can at best return the segment the desired time starts in; it doesn't return an accurate offset.
-	offset, err := twc.client.GetOffset(topic, partition, target.UnixNano()/int64(time.Millisecond))
-	if err == sarama.ErrOffsetOutOfRange {
-		// could not get time offset, falling back to mid offset.
-		offset = (lower + upper) / 2
+	fetch := func(offset int64) (*sarama.ConsumerMessage, error) {
+		return twc.messageAt(topic, partition, offset)
+	}
+	var (
+		tLower, tUpper       time.Time
+		haveLower, haveUpper bool
+	)
+	nextOffset := func() int64 {
+		if haveLower && haveUpper {
+			return interpolate(lower, upper, tLower, tUpper, target)
+		}
+		return (lower + upper) / 2
 	}
+	offset := nextOffset()
+	interpolated := false
 	for offset != lower && offset != upper {
-		t, err := twc.timeAt(topic, partition, offset)
+		width := upper - lower
+		var t time.Time
+		_, _, err := fetchSkipping(fetch, offset, upper, func(msg *sarama.ConsumerMessage) error {
+			tt, err := twc.extractor(msg)
+			if err != nil {
+				return err
+			}
+			t = tt
+			return nil
+		})
 		if err != nil {
 			return 0, err
 		}
 		if t.After(target) {
-			upper = offset
-			offset = (lower + offset) / 2
-			continue
+			upper, tUpper, haveUpper = offset, t, true
+		} else {
+			lower, tLower, haveLower = offset, t, true
+		}
+		if interpolated && upper-lower > width/2 {
+			// The interpolated probe failed to halve the interval, so
+			// bisect this round instead (the classic safeguard against
+			// adversarial distributions).
+			offset, interpolated = (lower+upper)/2, false
+		} else {
+			offset, interpolated = nextOffset(), haveLower && haveUpper
 		}
-		lower = offset
-		offset = (offset + upper) / 2
 	}
 	return offset, nil
 }
 
-func (twc *TimeWindow) bounds(topic string, partition int32) (lower, upper int64, err error) {
-	lower, err = twc.client.GetOffset(topic, partition, sarama.OffsetOldest)
-	if err != nil {
-		return
+// interpolate computes the next probe offset for interpolationSearch. It
+// falls back to the midpoint whenever the bounds carry no usable time
+// spread, and clamps the interpolated point into (lower, upper) so the search always
+// narrows.
+func interpolate(lower, upper int64, tLower, tUpper, target time.Time) int64 {
+	if !tUpper.After(tLower) || upper-lower <= 1 {
+		return (lower + upper) / 2
 	}
-	upper, err = twc.client.GetOffset(topic, partition, sarama.OffsetNewest)
-	return
+	frac := float64(target.Sub(tLower)) / float64(tUpper.Sub(tLower))
+	offset := lower + int64(float64(upper-lower)*frac)
+	if offset < lower+1 {
+		offset = lower + 1
+	} else if offset > upper-1 {
+		offset = upper - 1
+	}
+	return offset
 }
 
 func (twc *TimeWindow) timeAt(topic string, partition int32, offset int64) (time.Time, error) {
-	c, err := sarama.NewConsumerFromClient(twc.client)
+	msg, err := twc.messageAt(topic, partition, offset)
 	if err != nil {
 		return time.Time{}, err
 	}
+	return twc.extractor(msg)
+}
+
+func (twc *TimeWindow) messageAt(topic string, partition int32, offset int64) (*sarama.ConsumerMessage, error) {
+	c, err := sarama.NewConsumerFromClient(twc.client)
+	if err != nil {
+		return nil, err
+	}
 	defer c.Close()
 	pc, err := c.ConsumePartition(topic, partition, offset)
 	if err != nil {
-		return time.Time{}, err
+		return nil, err
 	}
 	defer pc.Close()
 	ctx, cancel := context.WithDeadline(context.Background(), time.Now().Add(time.Second*5))
 	defer cancel()
-	for {
-		select {
-		case <-ctx.Done():
-			return time.Time{}, fmt.Errorf("deadline exceeded for getting time at offset")
-		case msg := <-pc.Messages():
-			return msg.Timestamp, nil
-		}
+	select {
+	case <-ctx.Done():
+		return nil, fmt.Errorf("deadline exceeded for getting time at offset")
+	case msg := <-pc.Messages():
+		return msg, nil
 	}
 }
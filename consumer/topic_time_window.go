@@ -0,0 +1,290 @@
+package consumer
+
+import (
+	"container/heap"
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/Shopify/sarama"
+	"github.com/supershabam/sarama-cg"
+)
+
+// TopicTimeWindowConfig is used to create a new TopicTimeWindow.
+type TopicTimeWindowConfig struct {
+	CacheDuration       time.Duration
+	Client              sarama.Client
+	Context             context.Context
+	Coordinator         *cg.Coordinator
+	Start               StartPosition
+	Partitions          []int32
+	Topic               string
+	Window              time.Duration
+	TimeExtractor       func(*sarama.ConsumerMessage) (time.Time, error)
+	InterpolationSearch bool
+	// MaxSkew bounds how long TopicTimeWindow waits on a quiet partition
+	// before advancing without it. A partition that produces nothing for
+	// longer than MaxSkew stops blocking the merge; its messages are
+	// still folded in, in sorted position, whenever it does catch up.
+	// Zero means wait indefinitely for every partition.
+	MaxSkew time.Duration
+}
+
+// TopicTimeWindow runs a TimeWindow per partition and merges their output
+// into a single channel approximately ordered by extracted event time,
+// via a k-way merge keyed on each partition's next unread message. This
+// turns TimeWindow's single-partition rewind-by-window primitive into a
+// building block for time-windowed aggregations over a whole topic.
+type TopicTimeWindow struct {
+	windows    []*TimeWindow
+	partitions []int32
+	extractor  func(*sarama.ConsumerMessage) (time.Time, error)
+	maxSkew    time.Duration
+	out        chan *sarama.ConsumerMessage
+	watermarks chan Watermark
+	errCh      chan error
+}
+
+// NewTopicTimeWindow creates a TopicTimeWindow across the given
+// partitions that is ready to begin reading.
+func NewTopicTimeWindow(cfg *TopicTimeWindowConfig) (*TopicTimeWindow, error) {
+	extractor := cfg.TimeExtractor
+	if extractor == nil {
+		extractor = func(msg *sarama.ConsumerMessage) (time.Time, error) {
+			return msg.Timestamp, nil
+		}
+	}
+	windows := make([]*TimeWindow, 0, len(cfg.Partitions))
+	for _, partition := range cfg.Partitions {
+		w, err := NewTimeWindow(&TimeWindowConfig{
+			CacheDuration:       cfg.CacheDuration,
+			Client:              cfg.Client,
+			Context:             cfg.Context,
+			Coordinator:         cfg.Coordinator,
+			Start:               cfg.Start,
+			Partition:           partition,
+			Topic:               cfg.Topic,
+			Window:              cfg.Window,
+			TimeExtractor:       extractor,
+			InterpolationSearch: cfg.InterpolationSearch,
+		})
+		if err != nil {
+			return nil, err
+		}
+		windows = append(windows, w)
+	}
+	twt := &TopicTimeWindow{
+		windows:    windows,
+		partitions: cfg.Partitions,
+		extractor:  extractor,
+		maxSkew:    cfg.MaxSkew,
+		out:        make(chan *sarama.ConsumerMessage),
+		watermarks: make(chan Watermark, len(windows)),
+		errCh:      make(chan error, len(windows)),
+	}
+	go twt.run()
+	return twt, nil
+}
+
+// Consume returns a channel of Kafka messages merged across this
+// TopicTimeWindow's partitions, approximately ordered by extracted event
+// time. This channel closes once every partition has dropped out of the
+// merge, either because its TimeWindow closed or because extracting a
+// message's time failed.
+func (twt *TopicTimeWindow) Consume() <-chan *sarama.ConsumerMessage {
+	return twt.out
+}
+
+// Watermark is emitted on the channel returned by Watermarks whenever a
+// partition has gone quiet past MaxSkew and the merge advances without
+// waiting on it further, so downstream aggregation code has an explicit
+// signal that a round completed without that partition's contribution
+// instead of having to infer it from silence.
+type Watermark struct {
+	Partition int32
+	At        time.Time
+}
+
+// Watermarks returns a channel of Watermark events, one for every
+// partition that goes quiet past MaxSkew and gets skipped so the merge
+// can advance. A slow reader may miss ticks, since sends to this channel
+// never block the merge; treat it as a best-effort observability signal,
+// not as authoritative bookkeeping. This channel closes alongside
+// Consume.
+func (twt *TopicTimeWindow) Watermarks() <-chan Watermark {
+	return twt.watermarks
+}
+
+// Err should be called after the Consume channel closes to determine if
+// any partition's TimeWindow failed during processing.
+func (twt *TopicTimeWindow) Err() error {
+	select {
+	case err := <-twt.errCh:
+		return err
+	default:
+		return nil
+	}
+}
+
+// CommitOffset commits offset on the TimeWindow reading partition.
+func (twt *TopicTimeWindow) CommitOffset(partition int32, offset int64) error {
+	for i, p := range twt.partitions {
+		if p == partition {
+			return twt.windows[i].CommitOffset(offset)
+		}
+	}
+	return fmt.Errorf("consumer: partition %d is not part of this TopicTimeWindow", partition)
+}
+
+// partitionHead is the next unread, time-extracted message from one
+// partition's TimeWindow, as tracked by the merge heap.
+type partitionHead struct {
+	idx int
+	msg *sarama.ConsumerMessage
+	t   time.Time
+}
+
+// headHeap is a min-heap of partitionHead ordered by extracted time.
+type headHeap []*partitionHead
+
+func (h headHeap) Len() int            { return len(h) }
+func (h headHeap) Less(i, j int) bool  { return h[i].t.Before(h[j].t) }
+func (h headHeap) Swap(i, j int)       { h[i], h[j] = h[j], h[i] }
+func (h *headHeap) Push(x interface{}) { *h = append(*h, x.(*partitionHead)) }
+func (h *headHeap) Pop() interface{} {
+	old := *h
+	n := len(old)
+	item := old[n-1]
+	*h = old[:n-1]
+	return item
+}
+
+// pump repeatedly reads w's next message whenever it's asked to on
+// requests, and reports the extracted head (or the fact that w closed)
+// on heads. It is the one goroutine allowed to call w.Consume(), so the
+// merge loop in run can request exactly one message at a time from it.
+func (twt *TopicTimeWindow) pump(idx int, w *TimeWindow, requests <-chan struct{}, heads chan<- *partitionHead) {
+	for range requests {
+		msg, ok := <-w.Consume()
+		if !ok {
+			if err := w.Err(); err != nil {
+				twt.errCh <- err
+			}
+			heads <- &partitionHead{idx: idx}
+			return
+		}
+		t, err := twt.extractor(msg)
+		if err != nil {
+			twt.errCh <- err
+			heads <- &partitionHead{idx: idx}
+			return
+		}
+		heads <- &partitionHead{idx: idx, msg: msg, t: t}
+	}
+}
+
+func (twt *TopicTimeWindow) run() {
+	defer close(twt.out)
+	defer close(twt.watermarks)
+	n := len(twt.windows)
+	heads := make(chan *partitionHead)
+	requests := make([]chan struct{}, n)
+	for i, w := range twt.windows {
+		requests[i] = make(chan struct{}, 1)
+		go twt.pump(i, w, requests[i], heads)
+		requests[i] <- struct{}{}
+	}
+
+	h := &headHeap{}
+	heap.Init(h)
+	alive := n
+	// outstanding holds the index of every alive partition whose current
+	// head we require before emitting, i.e. the merge's quorum for this
+	// round. A partition drops out of outstanding either by reporting a
+	// head or by going quiet past MaxSkew. deadlines tracks, per
+	// partition in outstanding, the instant it was first required by --
+	// anchored to when it entered outstanding, not to the last head
+	// received from some other partition, so a steady trickle from fast
+	// partitions can't keep resetting a slow partition's budget.
+	outstanding := make(map[int]bool, n)
+	deadlines := make(map[int]time.Time, n)
+	for i := 0; i < n; i++ {
+		outstanding[i] = true
+		if twt.maxSkew > 0 {
+			deadlines[i] = time.Now().Add(twt.maxSkew)
+		}
+	}
+
+	for alive > 0 || h.Len() > 0 {
+		if len(outstanding) == 0 && h.Len() > 0 {
+			item := heap.Pop(h).(*partitionHead)
+			twt.out <- item.msg
+			outstanding[item.idx] = true
+			if twt.maxSkew > 0 {
+				deadlines[item.idx] = time.Now().Add(twt.maxSkew)
+			}
+			requests[item.idx] <- struct{}{}
+			continue
+		}
+		if alive == 0 && h.Len() == 0 {
+			break
+		}
+		var timer *time.Timer
+		var timerC <-chan time.Time
+		if twt.maxSkew > 0 {
+			if earliest, ok := earliestDeadline(outstanding, deadlines); ok {
+				timer = time.NewTimer(time.Until(earliest))
+				timerC = timer.C
+			}
+		}
+		select {
+		case head := <-heads:
+			if timer != nil {
+				timer.Stop()
+			}
+			delete(outstanding, head.idx)
+			delete(deadlines, head.idx)
+			if head.msg == nil {
+				alive--
+				continue
+			}
+			heap.Push(h, head)
+		case <-timerC:
+			// Forgive every outstanding partition whose own deadline has
+			// passed -- not the whole group -- so the merge can keep
+			// advancing with whatever's ready. A forgiven partition's
+			// head, once it does arrive, is simply pushed into the heap
+			// in sorted position.
+			now := time.Now()
+			for idx, deadline := range deadlines {
+				if now.Before(deadline) {
+					continue
+				}
+				delete(outstanding, idx)
+				delete(deadlines, idx)
+				select {
+				case twt.watermarks <- Watermark{Partition: twt.partitions[idx], At: now}:
+				default:
+				}
+			}
+		}
+	}
+}
+
+// earliestDeadline returns the soonest deadline among the partitions in
+// outstanding, and false if none of them carry one.
+func earliestDeadline(outstanding map[int]bool, deadlines map[int]time.Time) (time.Time, bool) {
+	var earliest time.Time
+	found := false
+	for idx := range outstanding {
+		deadline, ok := deadlines[idx]
+		if !ok {
+			continue
+		}
+		if !found || deadline.Before(earliest) {
+			earliest = deadline
+			found = true
+		}
+	}
+	return earliest, found
+}